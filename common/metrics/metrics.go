@@ -0,0 +1,119 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics defines a backend-agnostic telemetry surface. A component
+// declares the counters, gauges, and histograms it reports through a
+// Provider, and the caller wires in a concrete backend (Prometheus, StatsD,
+// or a disabled no-op) without the component itself depending on one
+package metrics
+
+// CounterOpts configures a Counter series. Namespace/Subsystem/Name combine
+// to form the series' fully qualified name, e.g. "deliver_blocks_sent"
+type CounterOpts struct {
+	Namespace  string
+	Subsystem  string
+	Name       string
+	Help       string
+	LabelNames []string
+}
+
+// GaugeOpts configures a Gauge series
+type GaugeOpts struct {
+	Namespace  string
+	Subsystem  string
+	Name       string
+	Help       string
+	LabelNames []string
+}
+
+// HistogramOpts configures a Histogram series. Buckets is only consulted by
+// backends that pre-aggregate observations into fixed buckets, e.g.
+// Prometheus; backends that don't may ignore it
+type HistogramOpts struct {
+	Namespace  string
+	Subsystem  string
+	Name       string
+	Help       string
+	LabelNames []string
+	Buckets    []float64
+}
+
+// Counter is a monotonically increasing series, e.g. a count of requests
+// served
+type Counter interface {
+	// With returns a Counter for this series with the given label
+	// values bound, supplied as alternating name/value pairs. It may be
+	// called without arguments on an unlabeled series
+	With(labelValues ...string) Counter
+	Add(delta float64)
+}
+
+// Gauge is a series that can move up or down, e.g. a number of open streams
+type Gauge interface {
+	// With returns a Gauge for this series with the given label values
+	// bound, supplied as alternating name/value pairs
+	With(labelValues ...string) Gauge
+	Add(delta float64)
+	Set(value float64)
+}
+
+// Histogram records observations of a value, e.g. a request duration, so a
+// backend can report their distribution
+type Histogram interface {
+	// With returns a Histogram for this series with the given label
+	// values bound, supplied as alternating name/value pairs
+	With(labelValues ...string) Histogram
+	Observe(value float64)
+}
+
+// Provider constructs the Counters, Gauges, and Histograms a component
+// reports through, backed by a specific telemetry system
+type Provider interface {
+	NewCounter(CounterOpts) Counter
+	NewGauge(GaugeOpts) Gauge
+	NewHistogram(HistogramOpts) Histogram
+}
+
+// NewDisabledProvider returns a Provider whose Counters, Gauges, and
+// Histograms discard every observation, so components can report metrics
+// unconditionally with zero overhead when no backend is configured
+func NewDisabledProvider() Provider {
+	return disabledProvider{}
+}
+
+type disabledProvider struct{}
+
+func (disabledProvider) NewCounter(CounterOpts) Counter       { return disabledCounter{} }
+func (disabledProvider) NewGauge(GaugeOpts) Gauge             { return disabledGauge{} }
+func (disabledProvider) NewHistogram(HistogramOpts) Histogram { return disabledHistogram{} }
+
+// disabledCounter, disabledGauge, and disabledHistogram implement Counter,
+// Gauge, and Histogram respectively by discarding every call
+type disabledCounter struct{}
+
+func (disabledCounter) With(labelValues ...string) Counter { return disabledCounter{} }
+func (disabledCounter) Add(delta float64)                  {}
+
+type disabledGauge struct{}
+
+func (disabledGauge) With(labelValues ...string) Gauge { return disabledGauge{} }
+func (disabledGauge) Add(delta float64)                {}
+func (disabledGauge) Set(value float64)                {}
+
+type disabledHistogram struct{}
+
+func (disabledHistogram) With(labelValues ...string) Histogram { return disabledHistogram{} }
+func (disabledHistogram) Observe(value float64)                {}
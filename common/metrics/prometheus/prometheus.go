@@ -0,0 +1,137 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package prometheus backs common/metrics with a Prometheus registry,
+// exposed for scraping by whatever HTTP handler the caller wires to
+// promhttp.Handler()
+package prometheus
+
+import (
+	"github.com/hyperledger/fabric/common/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Provider constructs Counters, Gauges, and Histograms registered against a
+// single Prometheus registry
+type Provider struct {
+	Registry *prometheus.Registry
+}
+
+// NewProvider constructs a Provider backed by a fresh Prometheus registry
+func NewProvider() *Provider {
+	return &Provider{Registry: prometheus.NewRegistry()}
+}
+
+func (p *Provider) registry() *prometheus.Registry {
+	if p.Registry == nil {
+		p.Registry = prometheus.NewRegistry()
+	}
+	return p.Registry
+}
+
+// NewCounter implements metrics.Provider
+func (p *Provider) NewCounter(opts metrics.CounterOpts) metrics.Counter {
+	cv := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: opts.Namespace,
+		Subsystem: opts.Subsystem,
+		Name:      opts.Name,
+		Help:      opts.Help,
+	}, opts.LabelNames)
+	p.registry().MustRegister(cv)
+	return &counter{cv: cv, labelNames: opts.LabelNames}
+}
+
+// NewGauge implements metrics.Provider
+func (p *Provider) NewGauge(opts metrics.GaugeOpts) metrics.Gauge {
+	gv := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: opts.Namespace,
+		Subsystem: opts.Subsystem,
+		Name:      opts.Name,
+		Help:      opts.Help,
+	}, opts.LabelNames)
+	p.registry().MustRegister(gv)
+	return &gauge{gv: gv, labelNames: opts.LabelNames}
+}
+
+// NewHistogram implements metrics.Provider
+func (p *Provider) NewHistogram(opts metrics.HistogramOpts) metrics.Histogram {
+	buckets := opts.Buckets
+	if len(buckets) == 0 {
+		buckets = prometheus.DefBuckets
+	}
+	hv := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: opts.Namespace,
+		Subsystem: opts.Subsystem,
+		Name:      opts.Name,
+		Help:      opts.Help,
+		Buckets:   buckets,
+	}, opts.LabelNames)
+	p.registry().MustRegister(hv)
+	return &histogram{hv: hv, labelNames: opts.LabelNames}
+}
+
+// labelValues zips the opts-declared label names with the values passed to
+// With, in the order Prometheus' *Vec.WithLabelValues expects
+func labelValues(labelNames []string, pairs []string) []string {
+	bound := make(map[string]string, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		bound[pairs[i]] = pairs[i+1]
+	}
+	values := make([]string, len(labelNames))
+	for i, name := range labelNames {
+		values[i] = bound[name]
+	}
+	return values
+}
+
+type counter struct {
+	cv         *prometheus.CounterVec
+	labelNames []string
+	bound      []string
+}
+
+func (c *counter) With(labelValuePairs ...string) metrics.Counter {
+	return &counter{cv: c.cv, labelNames: c.labelNames, bound: labelValues(c.labelNames, labelValuePairs)}
+}
+
+func (c *counter) Add(delta float64) {
+	c.cv.WithLabelValues(c.bound...).Add(delta)
+}
+
+type gauge struct {
+	gv         *prometheus.GaugeVec
+	labelNames []string
+	bound      []string
+}
+
+func (g *gauge) With(labelValuePairs ...string) metrics.Gauge {
+	return &gauge{gv: g.gv, labelNames: g.labelNames, bound: labelValues(g.labelNames, labelValuePairs)}
+}
+
+func (g *gauge) Add(delta float64) { g.gv.WithLabelValues(g.bound...).Add(delta) }
+func (g *gauge) Set(value float64) { g.gv.WithLabelValues(g.bound...).Set(value) }
+
+type histogram struct {
+	hv         *prometheus.HistogramVec
+	labelNames []string
+	bound      []string
+}
+
+func (h *histogram) With(labelValuePairs ...string) metrics.Histogram {
+	return &histogram{hv: h.hv, labelNames: h.labelNames, bound: labelValues(h.labelNames, labelValuePairs)}
+}
+
+func (h *histogram) Observe(value float64) { h.hv.WithLabelValues(h.bound...).Observe(value) }
@@ -0,0 +1,159 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package statsd backs common/metrics with a minimal StatsD client that
+// writes the wire protocol directly over UDP, so it has no dependency on a
+// particular client library
+package statsd
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/hyperledger/fabric/common/metrics"
+)
+
+// Provider constructs Counters, Gauges, and Histograms that report to a
+// StatsD daemon at Address, e.g. "127.0.0.1:8125". Every series name is
+// prefixed with Prefix, if set
+type Provider struct {
+	Address string
+	Prefix  string
+
+	connMu sync.Mutex
+	conn   net.Conn
+}
+
+// NewProvider constructs a Provider that reports to the StatsD daemon at
+// address. Dialing is lazy and best-effort: a daemon that is unreachable
+// when metrics are emitted is silently dropped rather than failing the
+// caller
+func NewProvider(address, prefix string) *Provider {
+	return &Provider{Address: address, Prefix: prefix}
+}
+
+func (p *Provider) send(bucket, value, kind string) {
+	p.connMu.Lock()
+	defer p.connMu.Unlock()
+	if p.conn == nil {
+		conn, err := net.Dial("udp", p.Address)
+		if err != nil {
+			return
+		}
+		p.conn = conn
+	}
+	name := bucket
+	if p.Prefix != "" {
+		name = p.Prefix + "." + bucket
+	}
+	fmt.Fprintf(p.conn, "%s:%s|%s", name, value, kind)
+}
+
+// statsdName builds a dotted metric name from a namespace, subsystem, name,
+// and bound label values, since the StatsD wire protocol has no concept of
+// labels
+func statsdName(namespace, subsystem, name string, labelNames, labelValuePairs []string) string {
+	parts := []string{}
+	for _, s := range []string{namespace, subsystem, name} {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	bound := make(map[string]string, len(labelValuePairs)/2)
+	for i := 0; i+1 < len(labelValuePairs); i += 2 {
+		bound[labelValuePairs[i]] = labelValuePairs[i+1]
+	}
+	for _, ln := range labelNames {
+		if v, ok := bound[ln]; ok && v != "" {
+			parts = append(parts, v)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// NewCounter implements metrics.Provider
+func (p *Provider) NewCounter(opts metrics.CounterOpts) metrics.Counter {
+	return &counter{p: p, namespace: opts.Namespace, subsystem: opts.Subsystem, name: opts.Name, labelNames: opts.LabelNames}
+}
+
+// NewGauge implements metrics.Provider
+func (p *Provider) NewGauge(opts metrics.GaugeOpts) metrics.Gauge {
+	return &gauge{p: p, namespace: opts.Namespace, subsystem: opts.Subsystem, name: opts.Name, labelNames: opts.LabelNames}
+}
+
+// NewHistogram implements metrics.Provider
+func (p *Provider) NewHistogram(opts metrics.HistogramOpts) metrics.Histogram {
+	return &histogram{p: p, namespace: opts.Namespace, subsystem: opts.Subsystem, name: opts.Name, labelNames: opts.LabelNames}
+}
+
+type counter struct {
+	p                          *Provider
+	namespace, subsystem, name string
+	labelNames                 []string
+	bound                      []string
+}
+
+func (c *counter) With(labelValuePairs ...string) metrics.Counter {
+	return &counter{p: c.p, namespace: c.namespace, subsystem: c.subsystem, name: c.name, labelNames: c.labelNames, bound: labelValuePairs}
+}
+
+func (c *counter) Add(delta float64) {
+	bucket := statsdName(c.namespace, c.subsystem, c.name, c.labelNames, c.bound)
+	c.p.send(bucket, fmt.Sprintf("%g", delta), "c")
+}
+
+type gauge struct {
+	p                          *Provider
+	namespace, subsystem, name string
+	labelNames                 []string
+	bound                      []string
+}
+
+func (g *gauge) With(labelValuePairs ...string) metrics.Gauge {
+	return &gauge{p: g.p, namespace: g.namespace, subsystem: g.subsystem, name: g.name, labelNames: g.labelNames, bound: labelValuePairs}
+}
+
+func (g *gauge) Add(delta float64) {
+	bucket := statsdName(g.namespace, g.subsystem, g.name, g.labelNames, g.bound)
+	sign := "+"
+	if delta < 0 {
+		sign = ""
+	}
+	g.p.send(bucket, fmt.Sprintf("%s%g", sign, delta), "g")
+}
+
+func (g *gauge) Set(value float64) {
+	bucket := statsdName(g.namespace, g.subsystem, g.name, g.labelNames, g.bound)
+	g.p.send(bucket, fmt.Sprintf("%g", value), "g")
+}
+
+type histogram struct {
+	p                          *Provider
+	namespace, subsystem, name string
+	labelNames                 []string
+	bound                      []string
+}
+
+func (h *histogram) With(labelValuePairs ...string) metrics.Histogram {
+	return &histogram{p: h.p, namespace: h.namespace, subsystem: h.subsystem, name: h.name, labelNames: h.labelNames, bound: labelValuePairs}
+}
+
+func (h *histogram) Observe(value float64) {
+	bucket := statsdName(h.namespace, h.subsystem, h.name, h.labelNames, h.bound)
+	h.p.send(bucket, fmt.Sprintf("%g", value), "ms")
+}
@@ -0,0 +1,49 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policies
+
+import (
+	cb "github.com/hyperledger/fabric/protos/common"
+)
+
+// Policy evaluates whether a set of signatures satisfies it
+type Policy interface {
+	Evaluate(signatureSet []*cb.SignedData) error
+}
+
+// Manager looks up policies by ID, or hands off to the Manager for a
+// nested config path
+type Manager interface {
+	// GetPolicy returns the policy registered for id, or false if none exists
+	GetPolicy(id string) (Policy, bool)
+
+	// Manager returns the Manager for a nested config path, or false if it
+	// does not exist
+	Manager(path []string) (Manager, bool)
+}
+
+const (
+	// ChannelReaders is the policy name for determining who may read from
+	// a channel, e.g. via the Deliver RPC
+	ChannelReaders = "/Channel/Readers"
+
+	// ChannelFilteredReaders is the policy name applied to deliver requests
+	// whose SeekInfo.ContentType is FILTERED_BLOCK, so application
+	// identities that only need transaction-validation events can subscribe
+	// without being granted full-block read rights
+	ChannelFilteredReaders = "/Channel/Application/FilteredReaders"
+)
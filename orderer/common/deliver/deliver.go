@@ -17,15 +17,21 @@ limitations under the License.
 package deliver
 
 import (
+	"fmt"
 	"io"
+	"sync"
+	"time"
 
+	"github.com/hyperledger/fabric/common/metrics"
 	"github.com/hyperledger/fabric/common/policies"
 	"github.com/hyperledger/fabric/orderer/common/filter"
 	"github.com/hyperledger/fabric/orderer/common/sigfilter"
+	"github.com/hyperledger/fabric/orderer/common/util"
 	"github.com/hyperledger/fabric/orderer/ledger"
 	cb "github.com/hyperledger/fabric/protos/common"
 	ab "github.com/hyperledger/fabric/protos/orderer"
 	"github.com/op/go-logging"
+	"golang.org/x/net/context"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/protos/utils"
@@ -50,78 +56,258 @@ type Support interface {
 
 	// Reader returns the chain Reader for the chain
 	Reader() ledger.Reader
+
+	// FilteredBlock projects a full block down to the header, channel ID, and
+	// a per-transaction validation summary, for subscribers that only care
+	// about transaction-validation events rather than full envelope payloads
+	FilteredBlock(block *cb.Block) *ab.FilteredBlock
+
+	// Sequence returns the current config sequence number for the chain. It
+	// increases every time the channel configuration changes, which lets a
+	// long-lived deliver stream detect that it should re-evaluate policy
+	Sequence() uint64
+
+	// ExpirationChecker returns a function which computes the expiration
+	// time of a serialized identity, so that a deliver stream can be closed
+	// once the signer's certificate is no longer valid
+	ExpirationChecker() ExpirationCheckFunc
+
+	// DeliverBlocker blocks a BLOCK_UNTIL_READY request until the cursor has
+	// a block ready, the stream's context is done (the client disconnected),
+	// or expiration fires (the signer's certificate has expired), and
+	// reports which of those conditions woke it up
+	DeliverBlocker(ctx context.Context, cursor ledger.Iterator, expiration <-chan time.Time) BlockWaitResult
 }
 
+// ExpirationCheckFunc returns the time at which the given serialized
+// identity expires
+type ExpirationCheckFunc func(identity []byte) time.Time
+
+// BlockWaitResult indicates which condition woke a DeliverBlocker call
+type BlockWaitResult int
+
+const (
+	// BlockReady indicates the cursor has a block ready to read
+	BlockReady BlockWaitResult = iota
+	// BlockContextDone indicates the stream's context ended, e.g. the client disconnected
+	BlockContextDone
+	// BlockExpired indicates the signer's certificate expired while waiting
+	BlockExpired
+)
+
 type deliverServer struct {
-	sm SupportManager
+	sm      SupportManager
+	tracer  Tracer
+	metrics *Metrics
+
+	maxInflight   int
+	inflight      chan struct{}
+	perChannel    int
+	channelSemsMu sync.Mutex
+	channelSems   map[string]chan struct{}
+}
+
+// Option configures optional behavior of the Handler returned by NewHandlerImpl
+type Option func(*deliverServer)
+
+// WithTracer configures the Handler to start OpenTracing spans around each
+// deliver session and block send using the given Tracer
+func WithTracer(tracer Tracer) Option {
+	return func(ds *deliverServer) {
+		ds.tracer = tracer
+	}
+}
+
+// WithMaxInflightStreams bounds the number of deliver streams the Handler
+// will service concurrently. Once the bound is reached, new streams are
+// rejected with Status_SERVICE_UNAVAILABLE instead of growing goroutines
+// without limit. n <= 0 means unlimited
+func WithMaxInflightStreams(n int) Option {
+	return func(ds *deliverServer) {
+		ds.maxInflight = n
+	}
+}
+
+// WithPerChannelLimit bounds the number of concurrent deliver streams the
+// Handler will service for any single channel. m <= 0 means unlimited
+func WithPerChannelLimit(m int) Option {
+	return func(ds *deliverServer) {
+		ds.perChannel = m
+	}
 }
 
 // NewHandlerImpl creates an implementation of the Handler interface
-func NewHandlerImpl(sm SupportManager) Handler {
-	return &deliverServer{
-		sm: sm,
+func NewHandlerImpl(sm SupportManager, opts ...Option) Handler {
+	ds := &deliverServer{
+		sm:          sm,
+		tracer:      noopTracer{},
+		metrics:     NewMetrics(metrics.NewDisabledProvider()),
+		channelSems: make(map[string]chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(ds)
+	}
+
+	if ds.maxInflight > 0 {
+		ds.inflight = make(chan struct{}, ds.maxInflight)
+	}
+
+	return ds
+}
+
+// acquireChannelSlot reserves one of ds.perChannel concurrent deliver streams
+// for channel, returning a release function and true on success, or a nil
+// release function and false if the channel is already at its limit
+func (ds *deliverServer) acquireChannelSlot(channel string) (func(), bool) {
+	if ds.perChannel <= 0 {
+		return func() {}, true
+	}
+
+	ds.channelSemsMu.Lock()
+	sem, ok := ds.channelSems[channel]
+	if !ok {
+		sem = make(chan struct{}, ds.perChannel)
+		ds.channelSems[channel] = sem
+	}
+	ds.channelSemsMu.Unlock()
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, true
+	default:
+		return nil, false
 	}
 }
 
 func (ds *deliverServer) Handle(srv ab.AtomicBroadcast_DeliverServer) error {
-	logger.Debugf("Starting new deliver loop")
+	ctx := srv.Context()
+	remoteAddr := util.ExtractRemoteAddress(ctx)
+
+	logger.Debugf("Starting new deliver loop for %s", remoteAddr)
+
+	if ds.inflight != nil {
+		select {
+		case ds.inflight <- struct{}{}:
+			defer func() { <-ds.inflight }()
+		default:
+			logger.Warningf("Rejecting deliver stream from %s: max inflight streams (%d) reached", remoteAddr, ds.maxInflight)
+			return ds.finishRequest(srv, "", cb.Status_SERVICE_UNAVAILABLE, time.Now())
+		}
+	}
+
+	ds.metrics.StreamsOpened.Add(1)
+	defer ds.metrics.StreamsClosed.Add(1)
+
+	var channelSlotHeld string
+	var releaseChannelSlot func()
+	defer func() {
+		if releaseChannelSlot != nil {
+			releaseChannelSlot()
+		}
+	}()
+
 	for {
-		logger.Debugf("Attempting to read seek info message")
+		logger.Debugf("Attempting to read seek info message from %s", remoteAddr)
+		start := time.Now()
 		envelope, err := srv.Recv()
 		if err == io.EOF {
-			logger.Debugf("Received EOF, hangup")
+			logger.Debugf("Received EOF from %s, hangup", remoteAddr)
 			return nil
 		}
 
 		if err != nil {
-			logger.Warningf("Error reading from stream: %s", err)
+			logger.Warningf("Error reading from stream for %s: %s", remoteAddr, err)
 			return err
 		}
 
 		payload, err := utils.UnmarshalPayload(envelope.Payload)
 		if err != nil {
-			logger.Warningf("Received an envelope with no payload: %s", err)
-			return sendStatusReply(srv, cb.Status_BAD_REQUEST)
+			logger.Warningf("Received an envelope with no payload from %s: %s", remoteAddr, err)
+			return ds.finishRequest(srv, "", cb.Status_BAD_REQUEST, start)
 		}
 
 		if payload.Header == nil {
-			logger.Warningf("Malformed envelope received with bad header")
-			return sendStatusReply(srv, cb.Status_BAD_REQUEST)
+			logger.Warningf("Malformed envelope received from %s with bad header", remoteAddr)
+			return ds.finishRequest(srv, "", cb.Status_BAD_REQUEST, start)
 		}
 
 		chdr, err := utils.UnmarshalChannelHeader(payload.Header.ChannelHeader)
 		if err != nil {
-			logger.Warningf("Failed to unmarshal channel header: %s", err)
-			return sendStatusReply(srv, cb.Status_BAD_REQUEST)
+			logger.Warningf("Failed to unmarshal channel header from %s: %s", remoteAddr, err)
+			return ds.finishRequest(srv, "", cb.Status_BAD_REQUEST, start)
 		}
 
 		chain, ok := ds.sm.GetChain(chdr.ChannelId)
 		if !ok {
 			// Note, we log this at DEBUG because SDKs will poll waiting for channels to be created
 			// So we would expect our log to be somewhat flooded with these
-			logger.Debugf("Client request for channel %s not found", chdr.ChannelId)
-			return sendStatusReply(srv, cb.Status_NOT_FOUND)
+			logger.Debugf("Client %s request for channel %s not found", remoteAddr, chdr.ChannelId)
+			return ds.finishRequest(srv, chdr.ChannelId, cb.Status_NOT_FOUND, start)
 		}
 
-		sf := sigfilter.New(policies.ChannelReaders, chain.PolicyManager())
-		result, _ := sf.Apply(envelope)
-		if result != filter.Forward {
-			logger.Warningf("Received unauthorized deliver request for channel %s", chdr.ChannelId)
-			return sendStatusReply(srv, cb.Status_FORBIDDEN)
+		if channelSlotHeld != chdr.ChannelId {
+			if releaseChannelSlot != nil {
+				releaseChannelSlot()
+				releaseChannelSlot = nil
+			}
+			release, ok := ds.acquireChannelSlot(chdr.ChannelId)
+			if !ok {
+				logger.Warningf("Rejecting deliver stream for channel %s from %s: max per-channel streams (%d) reached", chdr.ChannelId, remoteAddr, ds.perChannel)
+				return ds.finishRequest(srv, chdr.ChannelId, cb.Status_SERVICE_UNAVAILABLE, start)
+			}
+			releaseChannelSlot = release
+			channelSlotHeld = chdr.ChannelId
 		}
 
 		seekInfo := &ab.SeekInfo{}
 		if err = proto.Unmarshal(payload.Data, seekInfo); err != nil {
-			logger.Warningf("Received a signed deliver request with malformed seekInfo payload: %s", err)
-			return sendStatusReply(srv, cb.Status_BAD_REQUEST)
+			logger.Warningf("Received a signed deliver request with malformed seekInfo payload from %s: %s", remoteAddr, err)
+			return ds.finishRequest(srv, chdr.ChannelId, cb.Status_BAD_REQUEST, start)
+		}
+
+		span := ds.tracer.StartSpan("deliver", func() map[string]string {
+			return map[string]string{
+				"channel.id":     chdr.ChannelId,
+				"remote.address": remoteAddr,
+				"seek.start":     fmt.Sprintf("%v", seekInfo.Start),
+				"seek.stop":      fmt.Sprintf("%v", seekInfo.Stop),
+				"seek.behavior":  seekInfo.Behavior.String(),
+			}
+		})
+
+		policyName := policies.ChannelReaders
+		if seekInfo.ContentType == ab.SeekInfo_FILTERED_BLOCK {
+			policyName = policies.ChannelFilteredReaders
+		}
+
+		sigSpan := span.Child("sigfilter.apply")
+		sf := sigfilter.New(policyName, chain.PolicyManager())
+		result, _ := sf.Apply(envelope)
+		sigSpan.Finish()
+		if result != filter.Forward {
+			logger.Warningf("Received unauthorized deliver request for channel %s from %s", chdr.ChannelId, remoteAddr)
+			span.Finish()
+			return ds.finishRequest(srv, chdr.ChannelId, cb.Status_FORBIDDEN, start)
 		}
 
 		if seekInfo.Start == nil || seekInfo.Stop == nil {
-			logger.Warningf("Received seekInfo message with missing start or stop %v, %v", seekInfo.Start, seekInfo.Stop)
-			return sendStatusReply(srv, cb.Status_BAD_REQUEST)
+			logger.Warningf("Received seekInfo message from %s with missing start or stop %v, %v", remoteAddr, seekInfo.Start, seekInfo.Stop)
+			span.Finish()
+			return ds.finishRequest(srv, chdr.ChannelId, cb.Status_BAD_REQUEST, start)
+		}
+
+		sigHdr, err := utils.UnmarshalSignatureHeader(payload.Header.SignatureHeader)
+		if err != nil {
+			logger.Warningf("Received a signed deliver request from %s with malformed signature header: %s", remoteAddr, err)
+			span.Finish()
+			return ds.finishRequest(srv, chdr.ChannelId, cb.Status_BAD_REQUEST, start)
 		}
 
-		logger.Debugf("Received seekInfo (%p) %v for chain %s", seekInfo, seekInfo, chdr.ChannelId)
+		expirationTimer := time.NewTimer(expirationDuration(chain.ExpirationChecker()(sigHdr.Creator)))
+		configSeq := chain.Sequence()
+
+		logger.Debugf("Received seekInfo (%p) %v from %s for chain %s", seekInfo, seekInfo, remoteAddr, chdr.ChannelId)
 
 		cursor, number := chain.Reader().Iterator(seekInfo.Start)
 		var stopNum uint64
@@ -133,47 +319,128 @@ func (ds *deliverServer) Handle(srv ab.AtomicBroadcast_DeliverServer) error {
 		case *ab.SeekPosition_Specified:
 			stopNum = stop.Specified.Number
 			if stopNum < number {
-				logger.Warningf("Received invalid seekInfo message where start number %d is greater than stop number %d", number, stopNum)
-				return sendStatusReply(srv, cb.Status_BAD_REQUEST)
+				logger.Warningf("Received invalid seekInfo message from %s where start number %d is greater than stop number %d", remoteAddr, number, stopNum)
+				span.Finish()
+				expirationTimer.Stop()
+				return ds.finishRequest(srv, chdr.ChannelId, cb.Status_BAD_REQUEST, start)
 			}
 		}
 
 		for {
+			waitSpan := span.Child("iterator.wait")
 			if seekInfo.Behavior == ab.SeekInfo_BLOCK_UNTIL_READY {
-				<-cursor.ReadyChan()
+				switch chain.DeliverBlocker(ctx, cursor, expirationTimer.C) {
+				case BlockContextDone:
+					// The client disconnected mid-wait rather than the server reaching a
+					// terminal status, so it is not recorded in RequestsReceived/
+					// RequestDurationSeconds: like the io.EOF hangup above, there is no
+					// cb.Status value for "client went away" and those series are keyed
+					// only by cb.Status names
+					waitSpan.Finish()
+					span.Finish()
+					expirationTimer.Stop()
+					logger.Debugf("Client %s disconnected while waiting for new blocks on channel %s", remoteAddr, chdr.ChannelId)
+					return ctx.Err()
+				case BlockExpired:
+					waitSpan.Finish()
+					span.Finish()
+					logger.Warningf("Signer certificate for %s on channel %s has expired, closing stream", remoteAddr, chdr.ChannelId)
+					return ds.finishRequest(srv, chdr.ChannelId, cb.Status_FORBIDDEN, start)
+				}
 			} else {
 				select {
 				case <-cursor.ReadyChan():
 				default:
-					return sendStatusReply(srv, cb.Status_NOT_FOUND)
+					waitSpan.Finish()
+					span.Finish()
+					expirationTimer.Stop()
+					return ds.finishRequest(srv, chdr.ChannelId, cb.Status_NOT_FOUND, start)
 				}
 			}
+			waitSpan.Finish()
+
+			if newSeq := chain.Sequence(); newSeq != configSeq {
+				result, _ := sigfilter.New(policyName, chain.PolicyManager()).Apply(envelope)
+				if result != filter.Forward {
+					logger.Warningf("Channel configuration for %s changed, signer %s is no longer authorized", chdr.ChannelId, remoteAddr)
+					span.Finish()
+					expirationTimer.Stop()
+					return ds.finishRequest(srv, chdr.ChannelId, cb.Status_FORBIDDEN, start)
+				}
+				configSeq = newSeq
+			}
 
+			fetchSpan := span.Child("cursor.next")
 			block, status := cursor.Next()
+			fetchSpan.Finish()
 			if status != cb.Status_SUCCESS {
-				logger.Errorf("Error reading from channel, cause was: %v", status)
-				return sendStatusReply(srv, status)
+				logger.Errorf("Error reading from channel for %s, cause was: %v", remoteAddr, status)
+				span.Finish()
+				expirationTimer.Stop()
+				return ds.finishRequest(srv, chdr.ChannelId, status, start)
 			}
 
-			logger.Debugf("Delivering block for (%p) channel: %s", seekInfo, chdr.ChannelId)
+			logger.Debugf("Delivering block for (%p) to %s on channel: %s", seekInfo, remoteAddr, chdr.ChannelId)
 
-			if err := sendBlockReply(srv, block); err != nil {
-				logger.Warningf("Error sending to stream: %s", err)
+			sendSpan := span.Child("grpc.send")
+			var sent proto.Message
+			if seekInfo.ContentType == ab.SeekInfo_FILTERED_BLOCK {
+				filteredBlock := chain.FilteredBlock(block)
+				err = sendFilteredBlockReply(srv, filteredBlock)
+				sent = filteredBlock
+			} else {
+				err = sendBlockReply(srv, block)
+				sent = block
+			}
+			sendSpan.Finish()
+			if err != nil {
+				logger.Warningf("Error sending to stream for %s: %s", remoteAddr, err)
+				span.Finish()
+				expirationTimer.Stop()
 				return err
 			}
 
+			ds.metrics.BlocksSent.With("channel", chdr.ChannelId).Add(1)
+			ds.metrics.BytesSent.With("channel", chdr.ChannelId).Add(float64(proto.Size(sent)))
+
 			if stopNum == block.Header.Number {
 				break
 			}
 		}
 
-		if err := sendStatusReply(srv, cb.Status_SUCCESS); err != nil {
-			logger.Warningf("Error sending to stream: %s", err)
+		expirationTimer.Stop()
+
+		if err := ds.finishRequest(srv, chdr.ChannelId, cb.Status_SUCCESS, start); err != nil {
+			logger.Warningf("Error sending to stream for %s: %s", remoteAddr, err)
+			span.Finish()
 			return err
 		}
 
-		logger.Debugf("Done delivering for (%p), waiting for new SeekInfo", seekInfo)
+		span.Finish()
+		logger.Debugf("Done delivering for (%p) to %s, waiting for new SeekInfo", seekInfo, remoteAddr)
+	}
+}
+
+// neverExpires is used as the timer duration for identities whose expiration
+// time could not be determined, so that the stream is never closed on their
+// account
+const neverExpires = 100 * 365 * 24 * time.Hour
+
+// expirationDuration returns the time remaining until expiry, or neverExpires
+// if expiry is the zero value, meaning no expiration could be determined
+func expirationDuration(expiry time.Time) time.Duration {
+	if expiry.IsZero() {
+		return neverExpires
 	}
+	return time.Until(expiry)
+}
+
+// finishRequest records the terminal status and duration of a deliver
+// request before sending the status reply to the client
+func (ds *deliverServer) finishRequest(srv ab.AtomicBroadcast_DeliverServer, channel string, status cb.Status, start time.Time) error {
+	ds.metrics.RequestsReceived.With("channel", channel, "status", status.String()).Add(1)
+	observeRequestDuration(ds.metrics, channel, status.String(), start)
+	return sendStatusReply(srv, status)
 }
 
 func sendStatusReply(srv ab.AtomicBroadcast_DeliverServer, status cb.Status) error {
@@ -188,3 +455,9 @@ func sendBlockReply(srv ab.AtomicBroadcast_DeliverServer, block *cb.Block) error
 		Type: &ab.DeliverResponse_Block{Block: block},
 	})
 }
+
+func sendFilteredBlockReply(srv ab.AtomicBroadcast_DeliverServer, filteredBlock *ab.FilteredBlock) error {
+	return srv.Send(&ab.DeliverResponse{
+		Type: &ab.DeliverResponse_FilteredBlock{FilteredBlock: filteredBlock},
+	})
+}
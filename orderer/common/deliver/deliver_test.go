@@ -0,0 +1,413 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deliver
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/common/policies"
+	"github.com/hyperledger/fabric/orderer/ledger"
+	cb "github.com/hyperledger/fabric/protos/common"
+	ab "github.com/hyperledger/fabric/protos/orderer"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+var errDenied = errors.New("mock policy denied")
+
+// mockDeliverStream implements ab.AtomicBroadcast_DeliverServer over Go
+// channels so a test can drive Recv and observe Send without a real gRPC
+// connection
+type mockDeliverStream struct {
+	grpc.ServerStream
+
+	ctx    context.Context
+	recvCh chan *cb.Envelope
+	sentMu sync.Mutex
+	sent   []*ab.DeliverResponse
+}
+
+func newMockDeliverStream(ctx context.Context) *mockDeliverStream {
+	return &mockDeliverStream{ctx: ctx, recvCh: make(chan *cb.Envelope, 1)}
+}
+
+func (s *mockDeliverStream) Context() context.Context { return s.ctx }
+
+func (s *mockDeliverStream) Recv() (*cb.Envelope, error) {
+	env, ok := <-s.recvCh
+	if !ok {
+		return nil, io.EOF
+	}
+	return env, nil
+}
+
+func (s *mockDeliverStream) Send(resp *ab.DeliverResponse) error {
+	s.sentMu.Lock()
+	defer s.sentMu.Unlock()
+	s.sent = append(s.sent, resp)
+	return nil
+}
+
+func (s *mockDeliverStream) statuses() []cb.Status {
+	s.sentMu.Lock()
+	defer s.sentMu.Unlock()
+	var statuses []cb.Status
+	for _, resp := range s.sent {
+		if st, ok := resp.Type.(*ab.DeliverResponse_Status); ok {
+			statuses = append(statuses, st.Status)
+		}
+	}
+	return statuses
+}
+
+// mockPolicy allows or denies every signature set it is asked to evaluate,
+// flippable at runtime to simulate a channel configuration update revoking
+// a signer
+type mockPolicy struct {
+	mu   sync.Mutex
+	deny bool
+}
+
+func (p *mockPolicy) Evaluate(signatureSet []*cb.SignedData) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.deny {
+		return errDenied
+	}
+	return nil
+}
+
+func (p *mockPolicy) setDeny(deny bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.deny = deny
+}
+
+type mockPolicyManager struct {
+	policy *mockPolicy
+
+	// lastPolicyID records the policy name sigfilter most recently asked
+	// for, so a test can assert that a FILTERED_BLOCK request was
+	// evaluated against ChannelFilteredReaders rather than ChannelReaders
+	lastPolicyID string
+}
+
+func newMockPolicyManager() *mockPolicyManager {
+	return &mockPolicyManager{policy: &mockPolicy{}}
+}
+
+func (m *mockPolicyManager) GetPolicy(id string) (policies.Policy, bool) {
+	m.lastPolicyID = id
+	return m.policy, true
+}
+
+func (m *mockPolicyManager) Manager(path []string) (policies.Manager, bool) { return m, true }
+
+// mockIterator replays a fixed slice of blocks, signaling readiness
+// immediately. It is only ever driven by the single goroutine running
+// Handle, so next needs no synchronization
+type mockIterator struct {
+	blocks []*cb.Block
+	next   int
+}
+
+func (it *mockIterator) ReadyChan() <-chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+func (it *mockIterator) Next() (*cb.Block, cb.Status) {
+	if it.next >= len(it.blocks) {
+		return nil, cb.Status_SERVICE_UNAVAILABLE
+	}
+	b := it.blocks[it.next]
+	it.next++
+	return b, cb.Status_SUCCESS
+}
+
+type mockReader struct {
+	it     ledger.Iterator
+	height uint64
+}
+
+func (r *mockReader) Iterator(start *ab.SeekPosition) (ledger.Iterator, uint64) {
+	return r.it, 0
+}
+
+func (r *mockReader) Height() uint64 { return r.height }
+
+// mockSupport implements Support with test-controlled policy, sequence, and
+// block-wait behavior
+type mockSupport struct {
+	policyManager *mockPolicyManager
+	reader        *mockReader
+	seq           uint64
+	expiration    ExpirationCheckFunc
+	blockerResult BlockWaitResult
+	blockerWaitCh chan struct{} // closed by a test to unblock DeliverBlocker on demand
+
+	// blockerFunc, if set, overrides blockerResult/blockerWaitCh entirely so
+	// a test can react precisely to each DeliverBlocker call
+	blockerFunc func(ctx context.Context, cursor ledger.Iterator, expiration <-chan time.Time) BlockWaitResult
+}
+
+func (s *mockSupport) PolicyManager() policies.Manager { return s.policyManager }
+func (s *mockSupport) Reader() ledger.Reader           { return s.reader }
+
+func (s *mockSupport) FilteredBlock(block *cb.Block) *ab.FilteredBlock {
+	return &ab.FilteredBlock{Number: block.Header.Number}
+}
+
+func (s *mockSupport) Sequence() uint64 { return atomic.LoadUint64(&s.seq) }
+func (s *mockSupport) ExpirationChecker() ExpirationCheckFunc {
+	if s.expiration != nil {
+		return s.expiration
+	}
+	return func([]byte) time.Time { return time.Time{} }
+}
+
+func (s *mockSupport) DeliverBlocker(ctx context.Context, cursor ledger.Iterator, expiration <-chan time.Time) BlockWaitResult {
+	if s.blockerFunc != nil {
+		return s.blockerFunc(ctx, cursor, expiration)
+	}
+	if s.blockerWaitCh != nil {
+		select {
+		case <-s.blockerWaitCh:
+		case <-ctx.Done():
+			return BlockContextDone
+		case <-expiration:
+			return BlockExpired
+		}
+	}
+	return s.blockerResult
+}
+
+type mockSupportManager struct {
+	chains map[string]Support
+}
+
+func (m *mockSupportManager) GetChain(chainID string) (Support, bool) {
+	s, ok := m.chains[chainID]
+	return s, ok
+}
+
+// testEnvelope builds a signed deliver request for the given channel and
+// seek range
+func testEnvelope(t *testing.T, channel string, start, stop uint64) *cb.Envelope {
+	return seekEnvelope(t, channel, start, stop, ab.SeekInfo_FULL_BLOCK)
+}
+
+// seekEnvelope builds a signed deliver request for the given channel, seek
+// range, and content type
+func seekEnvelope(t *testing.T, channel string, start, stop uint64, contentType ab.SeekInfo_SeekContentType) *cb.Envelope {
+	seekInfo := &ab.SeekInfo{
+		Start:       &ab.SeekPosition{Type: &ab.SeekPosition_Specified{Specified: &ab.SeekSpecified{Number: start}}},
+		Stop:        &ab.SeekPosition{Type: &ab.SeekPosition_Specified{Specified: &ab.SeekSpecified{Number: stop}}},
+		Behavior:    ab.SeekInfo_BLOCK_UNTIL_READY,
+		ContentType: contentType,
+	}
+	seekInfoBytes, err := proto.Marshal(seekInfo)
+	if err != nil {
+		t.Fatalf("failed marshaling seekInfo: %s", err)
+	}
+
+	chdr := &cb.ChannelHeader{ChannelId: channel}
+	chdrBytes, err := proto.Marshal(chdr)
+	if err != nil {
+		t.Fatalf("failed marshaling channel header: %s", err)
+	}
+
+	sigHdr := &cb.SignatureHeader{Creator: serializedIdentity(t, []byte("not a pem certificate"))}
+	sigHdrBytes, err := proto.Marshal(sigHdr)
+	if err != nil {
+		t.Fatalf("failed marshaling signature header: %s", err)
+	}
+
+	payload := &cb.Payload{
+		Header: &cb.Header{ChannelHeader: chdrBytes, SignatureHeader: sigHdrBytes},
+		Data:   seekInfoBytes,
+	}
+	payloadBytes, err := proto.Marshal(payload)
+	if err != nil {
+		t.Fatalf("failed marshaling payload: %s", err)
+	}
+
+	return &cb.Envelope{Payload: payloadBytes}
+}
+
+func testBlock(number uint64) *cb.Block {
+	return &cb.Block{Header: &cb.BlockHeader{Number: number}}
+}
+
+func TestHandleClosesOnExpiryDuringBlockUntilReady(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	support := &mockSupport{
+		policyManager: newMockPolicyManager(),
+		reader:        &mockReader{it: &mockIterator{blocks: []*cb.Block{testBlock(0)}}},
+		blockerResult: BlockExpired,
+	}
+	ds := NewHandlerImpl(&mockSupportManager{chains: map[string]Support{"testchannel": support}}).(*deliverServer)
+
+	stream := newMockDeliverStream(ctx)
+	stream.recvCh <- testEnvelope(t, "testchannel", 0, 0)
+
+	if err := ds.Handle(stream); err != nil {
+		t.Fatalf("expected Handle to return nil on a graceful expiry close, got %s", err)
+	}
+
+	statuses := stream.statuses()
+	if len(statuses) != 1 || statuses[0] != cb.Status_FORBIDDEN {
+		t.Fatalf("expected a single FORBIDDEN status reply, got %v", statuses)
+	}
+}
+
+func TestHandleReconfigurationMidStreamRevokesSigner(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	support := &mockSupport{
+		policyManager: newMockPolicyManager(),
+		reader:        &mockReader{it: &mockIterator{blocks: []*cb.Block{testBlock(0), testBlock(1)}}},
+	}
+	// Deny the signer's policy right before the second block is about to be
+	// fetched, simulating a channel configuration update landing mid-stream
+	calls := 0
+	support.blockerFunc = func(context.Context, ledger.Iterator, <-chan time.Time) BlockWaitResult {
+		calls++
+		if calls == 2 {
+			atomic.AddUint64(&support.seq, 1)
+			support.policyManager.policy.setDeny(true)
+		}
+		return BlockReady
+	}
+	ds := NewHandlerImpl(&mockSupportManager{chains: map[string]Support{"testchannel": support}}).(*deliverServer)
+
+	stream := newMockDeliverStream(ctx)
+	// Ask for two blocks; the signer's policy is revoked after the first goes out
+	stream.recvCh <- testEnvelope(t, "testchannel", 0, 1)
+
+	if err := ds.Handle(stream); err != nil {
+		t.Fatalf("expected Handle to return nil after the signer was revoked, got %s", err)
+	}
+
+	statuses := stream.statuses()
+	if len(statuses) != 1 || statuses[0] != cb.Status_FORBIDDEN {
+		t.Fatalf("expected a single FORBIDDEN status reply once the signer was revoked, got %v", statuses)
+	}
+}
+
+func TestHandleRejectsWhenMaxInflightReached(t *testing.T) {
+	ds := NewHandlerImpl(&mockSupportManager{chains: map[string]Support{}}, WithMaxInflightStreams(1)).(*deliverServer)
+	ds.inflight <- struct{}{} // occupy the only inflight slot before the stream arrives
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := newMockDeliverStream(ctx)
+
+	if err := ds.Handle(stream); err != nil {
+		t.Fatalf("expected Handle to return nil when rejecting for max inflight, got %s", err)
+	}
+
+	statuses := stream.statuses()
+	if len(statuses) != 1 || statuses[0] != cb.Status_SERVICE_UNAVAILABLE {
+		t.Fatalf("expected a single SERVICE_UNAVAILABLE status reply, got %v", statuses)
+	}
+}
+
+// TestHandleExitsPromptlyOnClientDisconnectMidWait asserts that a client
+// disconnecting during a BLOCK_UNTIL_READY wait unblocks the Handle
+// goroutine instead of leaking it for the lifetime of the process
+func TestHandleExitsPromptlyOnClientDisconnectMidWait(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	support := &mockSupport{
+		policyManager: newMockPolicyManager(),
+		reader:        &mockReader{it: &mockIterator{}},
+		blockerWaitCh: make(chan struct{}), // never closed; only ctx cancellation unblocks DeliverBlocker
+	}
+	ds := NewHandlerImpl(&mockSupportManager{chains: map[string]Support{"testchannel": support}}).(*deliverServer)
+
+	stream := newMockDeliverStream(ctx)
+	stream.recvCh <- testEnvelope(t, "testchannel", 0, 0)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- ds.Handle(stream)
+	}()
+
+	cancel() // simulate the client disconnecting while Handle waits for a block
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("expected Handle to return context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Handle did not exit within 1s of the client disconnecting mid-wait")
+	}
+}
+
+// TestHandleSendsFilteredBlocksForFilteredContentType asserts that a
+// FILTERED_BLOCK request is evaluated against ChannelFilteredReaders rather
+// than ChannelReaders, and that the client receives FilteredBlock replies
+// built by Support.FilteredBlock rather than full blocks
+func TestHandleSendsFilteredBlocksForFilteredContentType(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	policyManager := newMockPolicyManager()
+	support := &mockSupport{
+		policyManager: policyManager,
+		reader:        &mockReader{it: &mockIterator{blocks: []*cb.Block{testBlock(0)}}},
+		blockerResult: BlockReady,
+	}
+	ds := NewHandlerImpl(&mockSupportManager{chains: map[string]Support{"testchannel": support}}).(*deliverServer)
+
+	stream := newMockDeliverStream(ctx)
+	stream.recvCh <- seekEnvelope(t, "testchannel", 0, 0, ab.SeekInfo_FILTERED_BLOCK)
+	close(stream.recvCh)
+
+	if err := ds.Handle(stream); err != nil {
+		t.Fatalf("expected Handle to return nil, got %s", err)
+	}
+
+	if policyManager.lastPolicyID != policies.ChannelFilteredReaders {
+		t.Fatalf("expected sigfilter to evaluate %s for a FILTERED_BLOCK request, got %s", policies.ChannelFilteredReaders, policyManager.lastPolicyID)
+	}
+
+	var filteredBlocks []*ab.FilteredBlock
+	for _, resp := range stream.sent {
+		if fb, ok := resp.Type.(*ab.DeliverResponse_FilteredBlock); ok {
+			filteredBlocks = append(filteredBlocks, fb.FilteredBlock)
+		}
+		if _, ok := resp.Type.(*ab.DeliverResponse_Block); ok {
+			t.Fatalf("expected no full Block replies for a FILTERED_BLOCK request")
+		}
+	}
+	if len(filteredBlocks) != 1 {
+		t.Fatalf("expected exactly one FilteredBlock reply, got %d", len(filteredBlocks))
+	}
+}
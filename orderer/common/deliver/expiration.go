@@ -0,0 +1,52 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deliver
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/protos/msp"
+)
+
+// DefaultExpirationCheck is the default ExpirationCheckFunc. It parses
+// identity as a serialized MSP identity wrapping a PEM encoded x509
+// certificate and returns the certificate's NotAfter time. Identities that
+// cannot be parsed as such, such as idemix credentials, are treated as
+// never expiring
+func DefaultExpirationCheck(identity []byte) time.Time {
+	sID := &msp.SerializedIdentity{}
+	if err := proto.Unmarshal(identity, sID); err != nil {
+		logger.Warningf("Failed unmarshaling identity during expiration check: %s", err)
+		return time.Time{}
+	}
+
+	block, _ := pem.Decode(sID.IdBytes)
+	if block == nil {
+		return time.Time{}
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		logger.Warningf("Failed parsing certificate during expiration check: %s", err)
+		return time.Time{}
+	}
+
+	return cert.NotAfter
+}
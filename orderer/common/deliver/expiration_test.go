@@ -0,0 +1,102 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deliver
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/protos/msp"
+)
+
+func pemEncodedCert(t *testing.T, notAfter time.Time) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed generating test key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test-signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed creating test certificate: %s", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func serializedIdentity(t *testing.T, idBytes []byte) []byte {
+	raw, err := proto.Marshal(&msp.SerializedIdentity{Mspid: "TestMSP", IdBytes: idBytes})
+	if err != nil {
+		t.Fatalf("failed marshaling serialized identity: %s", err)
+	}
+	return raw
+}
+
+func TestDefaultExpirationCheckReturnsCertNotAfter(t *testing.T) {
+	notAfter := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	identity := serializedIdentity(t, pemEncodedCert(t, notAfter))
+
+	expiry := DefaultExpirationCheck(identity)
+	if !expiry.Equal(notAfter) {
+		t.Fatalf("expected expiry %s, got %s", notAfter, expiry)
+	}
+}
+
+func TestDefaultExpirationCheckNeverExpiresOnUnparseableIdentity(t *testing.T) {
+	// An identity whose IdBytes are not a PEM certificate, such as an idemix
+	// credential, cannot be checked for expiration and must be treated as
+	// never expiring rather than rejected outright
+	identity := serializedIdentity(t, []byte("not a pem certificate"))
+
+	expiry := DefaultExpirationCheck(identity)
+	if !expiry.IsZero() {
+		t.Fatalf("expected zero expiry for an unparseable identity, got %s", expiry)
+	}
+
+	if d := expirationDuration(expiry); d != neverExpires {
+		t.Fatalf("expected expirationDuration to report neverExpires for a zero expiry, got %s", d)
+	}
+}
+
+func TestDefaultExpirationCheckNeverExpiresOnGarbageIdentity(t *testing.T) {
+	expiry := DefaultExpirationCheck([]byte("not even a serialized identity"))
+	if !expiry.IsZero() {
+		t.Fatalf("expected zero expiry for a garbage identity, got %s", expiry)
+	}
+}
+
+func TestExpirationDurationCountsDownToExpiry(t *testing.T) {
+	expiry := time.Now().Add(time.Hour)
+
+	d := expirationDuration(expiry)
+	if d <= 0 || d > time.Hour {
+		t.Fatalf("expected a positive duration no greater than an hour, got %s", d)
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deliver
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric/common/metrics"
+)
+
+// Metrics holds the counters and histograms the deliver handler reports
+// through the configured metrics.Provider
+type Metrics struct {
+	StreamsOpened          metrics.Counter
+	StreamsClosed          metrics.Counter
+	RequestsReceived       metrics.Counter
+	BlocksSent             metrics.Counter
+	BytesSent              metrics.Counter
+	RequestDurationSeconds metrics.Histogram
+}
+
+// NewMetrics constructs a Metrics that reports through the given Provider.
+// The Provider backend (Prometheus, StatsD, ...) determines how each series
+// is exposed
+func NewMetrics(p metrics.Provider) *Metrics {
+	return &Metrics{
+		StreamsOpened: p.NewCounter(metrics.CounterOpts{
+			Namespace: "deliver",
+			Name:      "streams_opened",
+			Help:      "The number of deliver streams that have been opened",
+		}),
+		StreamsClosed: p.NewCounter(metrics.CounterOpts{
+			Namespace: "deliver",
+			Name:      "streams_closed",
+			Help:      "The number of deliver streams that have been closed",
+		}),
+		RequestsReceived: p.NewCounter(metrics.CounterOpts{
+			Namespace:  "deliver",
+			Name:       "requests_received",
+			Help:       "The number of deliver requests received, by channel and terminal status",
+			LabelNames: []string{"channel", "status"},
+		}),
+		BlocksSent: p.NewCounter(metrics.CounterOpts{
+			Namespace:  "deliver",
+			Name:       "blocks_sent",
+			Help:       "The number of blocks sent to deliver clients, by channel",
+			LabelNames: []string{"channel"},
+		}),
+		BytesSent: p.NewCounter(metrics.CounterOpts{
+			Namespace:  "deliver",
+			Name:       "bytes_sent",
+			Help:       "The number of bytes sent to deliver clients, by channel",
+			LabelNames: []string{"channel"},
+		}),
+		RequestDurationSeconds: p.NewHistogram(metrics.HistogramOpts{
+			Namespace:  "deliver",
+			Name:       "request_duration_seconds",
+			Help:       "The time elapsed between receiving a deliver request and sending its terminal status, by channel and status",
+			LabelNames: []string{"channel", "status"},
+		}),
+	}
+}
+
+// WithMetrics configures the Handler to report the deliver telemetry surface
+// through the given Provider
+func WithMetrics(p metrics.Provider) Option {
+	return func(ds *deliverServer) {
+		ds.metrics = NewMetrics(p)
+	}
+}
+
+func observeRequestDuration(m *Metrics, channel string, status string, start time.Time) {
+	m.RequestDurationSeconds.With("channel", channel, "status", status).Observe(time.Since(start).Seconds())
+}
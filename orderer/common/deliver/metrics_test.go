@@ -0,0 +1,243 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deliver
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/common/metrics"
+	cb "github.com/hyperledger/fabric/protos/common"
+	ab "github.com/hyperledger/fabric/protos/orderer"
+	"golang.org/x/net/context"
+)
+
+// fakeObservation is a single Add/Observe call along with the label values
+// it was bound to via With
+type fakeObservation struct {
+	labels map[string]string
+	value  float64
+}
+
+// fakeSeries records every observation made against a Counter or Histogram
+// constructed from it, across all of the label-bound views returned by With
+type fakeSeries struct {
+	mu           sync.Mutex
+	labelNames   []string
+	observations []fakeObservation
+}
+
+func (s *fakeSeries) record(boundPairs []string, value float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bound := make(map[string]string, len(boundPairs)/2)
+	for i := 0; i+1 < len(boundPairs); i += 2 {
+		bound[boundPairs[i]] = boundPairs[i+1]
+	}
+	s.observations = append(s.observations, fakeObservation{labels: bound, value: value})
+}
+
+// sum totals every observation whose labels match all of want
+func (s *fakeSeries) sum(want map[string]string) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var total float64
+	for _, o := range s.observations {
+		if matches(o.labels, want) {
+			total += o.value
+		}
+	}
+	return total
+}
+
+func matches(labels, want map[string]string) bool {
+	for k, v := range want {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+type fakeCounter struct {
+	series *fakeSeries
+	bound  []string
+}
+
+func (c *fakeCounter) With(labelValuePairs ...string) metrics.Counter {
+	return &fakeCounter{series: c.series, bound: labelValuePairs}
+}
+
+func (c *fakeCounter) Add(delta float64) { c.series.record(c.bound, delta) }
+
+type fakeHistogram struct {
+	series *fakeSeries
+	bound  []string
+}
+
+func (h *fakeHistogram) With(labelValuePairs ...string) metrics.Histogram {
+	return &fakeHistogram{series: h.series, bound: labelValuePairs}
+}
+
+func (h *fakeHistogram) Observe(value float64) { h.series.record(h.bound, value) }
+
+type fakeGauge struct{ series *fakeSeries }
+
+func (g *fakeGauge) With(labelValuePairs ...string) metrics.Gauge {
+	return &fakeGauge{series: g.series}
+}
+func (g *fakeGauge) Add(delta float64) { g.series.record(nil, delta) }
+func (g *fakeGauge) Set(value float64) { g.series.record(nil, value) }
+
+// fakeProvider is a metrics.Provider that hands back fakeSeries-backed
+// Counters, Gauges, and Histograms keyed by series name, so a test can
+// assert on what a handler reported without a real telemetry backend
+type fakeProvider struct {
+	mu     sync.Mutex
+	series map[string]*fakeSeries
+}
+
+func newFakeProvider() *fakeProvider {
+	return &fakeProvider{series: map[string]*fakeSeries{}}
+}
+
+func (p *fakeProvider) seriesFor(name string, labelNames []string) *fakeSeries {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.series[name]
+	if !ok {
+		s = &fakeSeries{labelNames: labelNames}
+		p.series[name] = s
+	}
+	return s
+}
+
+func (p *fakeProvider) NewCounter(opts metrics.CounterOpts) metrics.Counter {
+	return &fakeCounter{series: p.seriesFor(opts.Name, opts.LabelNames)}
+}
+
+func (p *fakeProvider) NewGauge(opts metrics.GaugeOpts) metrics.Gauge {
+	return &fakeGauge{series: p.seriesFor(opts.Name, opts.LabelNames)}
+}
+
+func (p *fakeProvider) NewHistogram(opts metrics.HistogramOpts) metrics.Histogram {
+	return &fakeHistogram{series: p.seriesFor(opts.Name, opts.LabelNames)}
+}
+
+func TestMetricsRecordedOnSuccessfulDeliver(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	provider := newFakeProvider()
+	support := &mockSupport{
+		policyManager: newMockPolicyManager(),
+		reader:        &mockReader{it: &mockIterator{blocks: []*cb.Block{testBlock(0)}}},
+		blockerResult: BlockReady,
+	}
+	ds := NewHandlerImpl(&mockSupportManager{chains: map[string]Support{"testchannel": support}}, WithMetrics(provider)).(*deliverServer)
+
+	stream := newMockDeliverStream(ctx)
+	stream.recvCh <- testEnvelope(t, "testchannel", 0, 0)
+	close(stream.recvCh)
+
+	if err := ds.Handle(stream); err != nil {
+		t.Fatalf("expected Handle to return nil, got %s", err)
+	}
+
+	wantBytes := float64(proto.Size(testBlock(0)))
+
+	requestsReceived := provider.series["requests_received"].sum(map[string]string{"channel": "testchannel", "status": "SUCCESS"})
+	if requestsReceived != 1 {
+		t.Fatalf("expected one SUCCESS requests_received observation for testchannel, got %v", requestsReceived)
+	}
+
+	blocksSent := provider.series["blocks_sent"].sum(map[string]string{"channel": "testchannel"})
+	if blocksSent != 1 {
+		t.Fatalf("expected blocks_sent to be 1, got %v", blocksSent)
+	}
+
+	bytesSent := provider.series["bytes_sent"].sum(map[string]string{"channel": "testchannel"})
+	if bytesSent != wantBytes {
+		t.Fatalf("expected bytes_sent to be %v (the marshaled block size), got %v", wantBytes, bytesSent)
+	}
+
+	durations := provider.series["request_duration_seconds"].observations
+	if len(durations) != 1 || durations[0].labels["channel"] != "testchannel" || durations[0].labels["status"] != "SUCCESS" {
+		t.Fatalf("expected one SUCCESS request_duration_seconds observation for testchannel, got %v", durations)
+	}
+}
+
+func TestMetricsRecordBytesSentForFilteredBlocks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	provider := newFakeProvider()
+	support := &mockSupport{
+		policyManager: newMockPolicyManager(),
+		reader:        &mockReader{it: &mockIterator{blocks: []*cb.Block{testBlock(7)}}},
+		blockerResult: BlockReady,
+	}
+	ds := NewHandlerImpl(&mockSupportManager{chains: map[string]Support{"testchannel": support}}, WithMetrics(provider)).(*deliverServer)
+
+	stream := newMockDeliverStream(ctx)
+	stream.recvCh <- seekEnvelope(t, "testchannel", 7, 7, ab.SeekInfo_FILTERED_BLOCK)
+	close(stream.recvCh)
+
+	if err := ds.Handle(stream); err != nil {
+		t.Fatalf("expected Handle to return nil, got %s", err)
+	}
+
+	wantBytes := float64(proto.Size(support.FilteredBlock(testBlock(7))))
+	fullBlockBytes := float64(proto.Size(testBlock(7)))
+	if wantBytes >= fullBlockBytes {
+		t.Fatalf("test fixture is not useful: FilteredBlock (%v bytes) is not smaller than the full block (%v bytes)", wantBytes, fullBlockBytes)
+	}
+
+	bytesSent := provider.series["bytes_sent"].sum(map[string]string{"channel": "testchannel"})
+	if bytesSent != wantBytes {
+		t.Fatalf("expected bytes_sent to reflect the FilteredBlock size (%v), got %v", wantBytes, bytesSent)
+	}
+}
+
+func TestMetricsRecordedOnRejection(t *testing.T) {
+	provider := newFakeProvider()
+	ds := NewHandlerImpl(&mockSupportManager{chains: map[string]Support{}}, WithMetrics(provider), WithMaxInflightStreams(1)).(*deliverServer)
+	ds.inflight <- struct{}{} // occupy the only inflight slot before the stream arrives
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := newMockDeliverStream(ctx)
+
+	if err := ds.Handle(stream); err != nil {
+		t.Fatalf("expected Handle to return nil when rejecting for max inflight, got %s", err)
+	}
+
+	requestsReceived := provider.series["requests_received"].sum(map[string]string{"channel": "", "status": "SERVICE_UNAVAILABLE"})
+	if requestsReceived != 1 {
+		t.Fatalf("expected one SERVICE_UNAVAILABLE requests_received observation, got %v", requestsReceived)
+	}
+
+	durations := provider.series["request_duration_seconds"].observations
+	if len(durations) != 1 || durations[0].labels["status"] != "SERVICE_UNAVAILABLE" {
+		t.Fatalf("expected one SERVICE_UNAVAILABLE request_duration_seconds observation, got %v", durations)
+	}
+
+	if blocksSent := provider.series["blocks_sent"]; blocksSent != nil && len(blocksSent.observations) != 0 {
+		t.Fatalf("expected no blocks_sent observations for a rejected stream, got %v", blocksSent.observations)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deliver
+
+import (
+	"fmt"
+	"io"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	zipkin "github.com/openzipkin/zipkin-go-opentracing"
+	jaeger "github.com/uber/jaeger-client-go"
+	jaegercfg "github.com/uber/jaeger-client-go/transport"
+)
+
+// TracingConfig controls how a Tracer reports spans for the deliver service.
+// It mirrors the configuration shape used elsewhere for Zipkin support.
+type TracingConfig struct {
+	// CollectorType selects the backend a Tracer reports spans to, e.g.
+	// "zipkin" or "jaeger". An empty value disables tracing.
+	CollectorType string
+	// ConnectString is the collector endpoint, e.g. a Zipkin HTTP collector URL
+	ConnectString string
+	// SamplerRate is the fraction, between 0 and 1, of deliver sessions to trace
+	SamplerRate float64
+	// ServiceName identifies this orderer instance to the collector
+	ServiceName string
+}
+
+// Span represents a single unit of work within a traced deliver session
+type Span interface {
+	// Child starts a new child span with the given operation name
+	Child(operationName string) Span
+	// Finish marks the span as complete
+	Finish()
+}
+
+// Tracer starts spans for deliver sessions. A Tracer must be safe to share
+// across concurrently running streams
+type Tracer interface {
+	// StartSpan begins a new parent span for a deliver loop iteration. tags
+	// is called to obtain the span's key/value pairs only if the Tracer
+	// actually records spans, so building the tag set costs nothing when
+	// tracing is disabled
+	StartSpan(operationName string, tags func() map[string]string) Span
+
+	// Close flushes any buffered spans and releases the underlying
+	// collector connection. It should be called once, on process shutdown
+	Close() error
+}
+
+// NewTracer constructs a Tracer from the given configuration. When
+// cfg.CollectorType is empty, tracing is disabled and the returned Tracer is
+// a zero-overhead no-op
+func NewTracer(cfg TracingConfig) (Tracer, error) {
+	if cfg.CollectorType == "" {
+		return noopTracer{}, nil
+	}
+
+	switch cfg.CollectorType {
+	case "zipkin":
+		return newZipkinTracer(cfg)
+	case "jaeger":
+		return newJaegerTracer(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported tracing collector type: %s", cfg.CollectorType)
+	}
+}
+
+func newZipkinTracer(cfg TracingConfig) (Tracer, error) {
+	collector, err := zipkin.NewHTTPCollector(cfg.ConnectString)
+	if err != nil {
+		return nil, err
+	}
+
+	recorder := zipkin.NewRecorder(collector, false, cfg.ConnectString, cfg.ServiceName)
+	ot, err := zipkin.NewTracer(
+		recorder,
+		zipkin.WithSampler(zipkin.NewBoundarySampler(cfg.SamplerRate, 0)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &openTracingTracer{tracer: ot, closer: collector}, nil
+}
+
+func newJaegerTracer(cfg TracingConfig) (Tracer, error) {
+	transport := jaegercfg.NewHTTPTransport(cfg.ConnectString)
+	reporter := jaeger.NewRemoteReporter(transport)
+
+	sampler, err := jaeger.NewProbabilisticSampler(cfg.SamplerRate)
+	if err != nil {
+		return nil, err
+	}
+
+	ot, closer := jaeger.NewTracer(cfg.ServiceName, sampler, reporter)
+
+	return &openTracingTracer{tracer: ot, closer: closer}, nil
+}
+
+type openTracingTracer struct {
+	tracer opentracing.Tracer
+	closer io.Closer
+}
+
+func (t *openTracingTracer) StartSpan(operationName string, tags func() map[string]string) Span {
+	span := t.tracer.StartSpan(operationName)
+	for k, v := range tags() {
+		span.SetTag(k, v)
+	}
+	return &openTracingSpan{tracer: t.tracer, span: span}
+}
+
+func (t *openTracingTracer) Close() error {
+	if t.closer == nil {
+		return nil
+	}
+	return t.closer.Close()
+}
+
+type openTracingSpan struct {
+	tracer opentracing.Tracer
+	span   opentracing.Span
+}
+
+func (s *openTracingSpan) Child(operationName string) Span {
+	child := s.tracer.StartSpan(operationName, opentracing.ChildOf(s.span.Context()))
+	return &openTracingSpan{tracer: s.tracer, span: child}
+}
+
+func (s *openTracingSpan) Finish() {
+	s.span.Finish()
+}
+
+// noopTracer is the zero-overhead Tracer used when tracing is disabled
+type noopTracer struct{}
+
+func (noopTracer) StartSpan(operationName string, tags func() map[string]string) Span {
+	return noopSpan{}
+}
+
+func (noopTracer) Close() error { return nil }
+
+type noopSpan struct{}
+
+func (noopSpan) Child(operationName string) Span { return noopSpan{} }
+func (noopSpan) Finish()                         {}
@@ -0,0 +1,34 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package util holds small helpers shared across the orderer's gRPC service
+// implementations
+package util
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/peer"
+)
+
+// ExtractRemoteAddress returns the address of the peer at the other end of
+// ctx, or "unknown" if it cannot be determined
+func ExtractRemoteAddress(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "unknown"
+	}
+	return p.Addr.String()
+}
@@ -0,0 +1,194 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package orderer
+
+import (
+	cb "github.com/hyperledger/fabric/protos/common"
+	"golang.org/x/net/context"
+)
+
+// SeekInfo_SeekBehavior controls what the deliver service does when the next
+// requested block is not yet available: wait for it, or fail the request
+type SeekInfo_SeekBehavior int32
+
+const (
+	SeekInfo_BLOCK_UNTIL_READY SeekInfo_SeekBehavior = 0
+	SeekInfo_FAIL_IF_NOT_READY SeekInfo_SeekBehavior = 1
+)
+
+func (x SeekInfo_SeekBehavior) String() string {
+	switch x {
+	case SeekInfo_BLOCK_UNTIL_READY:
+		return "BLOCK_UNTIL_READY"
+	case SeekInfo_FAIL_IF_NOT_READY:
+		return "FAIL_IF_NOT_READY"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// SeekInfo_SeekContentType selects whether a deliver client receives full
+// block envelopes or a FilteredBlock projection containing only
+// transaction-validation events
+type SeekInfo_SeekContentType int32
+
+const (
+	SeekInfo_FULL_BLOCK     SeekInfo_SeekContentType = 0
+	SeekInfo_FILTERED_BLOCK SeekInfo_SeekContentType = 1
+)
+
+func (x SeekInfo_SeekContentType) String() string {
+	switch x {
+	case SeekInfo_FULL_BLOCK:
+		return "FULL_BLOCK"
+	case SeekInfo_FILTERED_BLOCK:
+		return "FILTERED_BLOCK"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// SeekInfo instructs the deliver service which blocks a client wants
+// delivered, and in which form
+type SeekInfo struct {
+	Start       *SeekPosition            `protobuf:"bytes,1,opt,name=start" json:"start,omitempty"`
+	Stop        *SeekPosition            `protobuf:"bytes,2,opt,name=stop" json:"stop,omitempty"`
+	Behavior    SeekInfo_SeekBehavior    `protobuf:"varint,3,opt,name=behavior,enum=orderer.SeekInfo_SeekBehavior" json:"behavior,omitempty"`
+	ContentType SeekInfo_SeekContentType `protobuf:"varint,4,opt,name=content_type,json=contentType,enum=orderer.SeekInfo_SeekContentType" json:"content_type,omitempty"`
+}
+
+func (m *SeekInfo) Reset()         { *m = SeekInfo{} }
+func (m *SeekInfo) String() string { return "" }
+func (*SeekInfo) ProtoMessage()    {}
+
+// SeekPosition wraps the oldest/newest/specified seek position a client
+// asked to start or stop at
+type SeekPosition struct {
+	Type isSeekPosition_Type `protobuf_oneof:"Type"`
+}
+
+func (m *SeekPosition) Reset()         { *m = SeekPosition{} }
+func (m *SeekPosition) String() string { return "" }
+func (*SeekPosition) ProtoMessage()    {}
+
+// XXX_OneofWrappers lets proto.Marshal/Unmarshal discover the concrete
+// types behind the Type oneof field
+func (*SeekPosition) XXX_OneofWrappers() []interface{} {
+	return []interface{}{
+		(*SeekPosition_Oldest)(nil),
+		(*SeekPosition_Newest)(nil),
+		(*SeekPosition_Specified)(nil),
+	}
+}
+
+type isSeekPosition_Type interface {
+	isSeekPosition_Type()
+}
+
+type SeekPosition_Oldest struct {
+	Oldest *SeekOldest `protobuf:"bytes,1,opt,name=oldest,oneof"`
+}
+type SeekPosition_Newest struct {
+	Newest *SeekNewest `protobuf:"bytes,2,opt,name=newest,oneof"`
+}
+type SeekPosition_Specified struct {
+	Specified *SeekSpecified `protobuf:"bytes,3,opt,name=specified,oneof"`
+}
+
+func (*SeekPosition_Oldest) isSeekPosition_Type()    {}
+func (*SeekPosition_Newest) isSeekPosition_Type()    {}
+func (*SeekPosition_Specified) isSeekPosition_Type() {}
+
+type SeekOldest struct{}
+type SeekNewest struct{}
+
+// SeekSpecified seeks to a specific, known block number
+type SeekSpecified struct {
+	Number uint64 `protobuf:"varint,1,opt,name=number" json:"number,omitempty"`
+}
+
+// DeliverResponse is the message sent back to a deliver client: a terminal
+// status, a full block, or, for FILTERED_BLOCK subscribers, a FilteredBlock
+type DeliverResponse struct {
+	Type isDeliverResponse_Type `protobuf_oneof:"Type"`
+}
+
+func (m *DeliverResponse) Reset()         { *m = DeliverResponse{} }
+func (m *DeliverResponse) String() string { return "" }
+func (*DeliverResponse) ProtoMessage()    {}
+
+// XXX_OneofWrappers lets proto.Marshal/Unmarshal discover the concrete
+// types behind the Type oneof field
+func (*DeliverResponse) XXX_OneofWrappers() []interface{} {
+	return []interface{}{
+		(*DeliverResponse_Status)(nil),
+		(*DeliverResponse_Block)(nil),
+		(*DeliverResponse_FilteredBlock)(nil),
+	}
+}
+
+type isDeliverResponse_Type interface {
+	isDeliverResponse_Type()
+}
+
+type DeliverResponse_Status struct {
+	Status cb.Status `protobuf:"varint,1,opt,name=status,enum=common.Status,oneof"`
+}
+type DeliverResponse_Block struct {
+	Block *cb.Block `protobuf:"bytes,2,opt,name=block,oneof"`
+}
+type DeliverResponse_FilteredBlock struct {
+	FilteredBlock *FilteredBlock `protobuf:"bytes,3,opt,name=filtered_block,json=filteredBlock,oneof"`
+}
+
+func (*DeliverResponse_Status) isDeliverResponse_Type()        {}
+func (*DeliverResponse_Block) isDeliverResponse_Type()         {}
+func (*DeliverResponse_FilteredBlock) isDeliverResponse_Type() {}
+
+// FilteredBlock is a block projected down to the header, channel ID, and a
+// per-transaction validation summary, for subscribers that only care about
+// transaction-validation events rather than full envelope payloads
+type FilteredBlock struct {
+	ChannelId            string                 `protobuf:"bytes,1,opt,name=channel_id,json=channelId" json:"channel_id,omitempty"`
+	Number               uint64                 `protobuf:"varint,2,opt,name=number" json:"number,omitempty"`
+	Header               *cb.BlockHeader        `protobuf:"bytes,3,opt,name=header" json:"header,omitempty"`
+	FilteredTransactions []*FilteredTransaction `protobuf:"bytes,4,rep,name=filtered_transactions,json=filteredTransactions" json:"filtered_transactions,omitempty"`
+}
+
+func (m *FilteredBlock) Reset()         { *m = FilteredBlock{} }
+func (m *FilteredBlock) String() string { return "" }
+func (*FilteredBlock) ProtoMessage()    {}
+
+// FilteredTransaction summarizes a single transaction within a FilteredBlock
+type FilteredTransaction struct {
+	TxId             string              `protobuf:"bytes,1,opt,name=tx_id,json=txId" json:"tx_id,omitempty"`
+	TxValidationCode cb.TxValidationCode `protobuf:"varint,2,opt,name=tx_validation_code,json=txValidationCode,enum=common.TxValidationCode" json:"tx_validation_code,omitempty"`
+	ChaincodeId      string              `protobuf:"bytes,3,opt,name=chaincode_id,json=chaincodeId" json:"chaincode_id,omitempty"`
+	EventName        string              `protobuf:"bytes,4,opt,name=event_name,json=eventName" json:"event_name,omitempty"`
+}
+
+func (m *FilteredTransaction) Reset()         { *m = FilteredTransaction{} }
+func (m *FilteredTransaction) String() string { return "" }
+func (*FilteredTransaction) ProtoMessage()    {}
+
+// AtomicBroadcast_DeliverServer is the server-side stream a Handler drives:
+// Recv reads the next signed SeekInfo envelope, Send writes a DeliverResponse
+type AtomicBroadcast_DeliverServer interface {
+	Recv() (*cb.Envelope, error)
+	Send(*DeliverResponse) error
+	Context() context.Context
+}